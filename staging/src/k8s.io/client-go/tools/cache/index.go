@@ -18,6 +18,8 @@ package cache
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -66,10 +68,179 @@ type Indexer interface {
 	// 返回indexers
 	GetIndexers() Indexers
 
-	// AddIndexers adds more indexers to this store.  If you call this after you already have data
-	// in the store, the results are undefined.
-	// 添加Indexer
+	// AddIndexers adds more indexers to this store. If called after the store
+	// already has data, each new IndexFunc is run over every existing item
+	// under the write lock to populate Indices[name] before AddIndexers
+	// returns, so the new index is immediately queryable and never
+	// partially built.
+	// 添加Indexer，即便此时store中已有数据，也会在写锁内对现有的每个item
+	// 运行一遍新的IndexFunc来补齐Indices[name]，保证返回时索引已经完整可查
 	AddIndexers(newIndexers Indexers) error
+
+	// RemoveIndexer removes the named indexer and discards Indices[name].
+	// Removing an indexer that was never added is a no-op and returns nil.
+	RemoveIndexer(name string) error
+
+	// ReplaceIndexer atomically swaps the IndexFunc registered under name
+	// for f and rebuilds only Indices[name] from the store's current
+	// contents, leaving every other index untouched. This lets a controller
+	// change how an index is computed (e.g. after discovering a new CRD
+	// relationship) without tearing down and rebuilding the whole informer.
+	ReplaceIndexer(name string, f IndexFunc) error
+
+	// SetIndexerEventHandler registers an optional handler that is notified
+	// of reindexing progress performed by AddIndexers, RemoveIndexer and
+	// ReplaceIndexer. A nil handler disables notifications.
+	SetIndexerEventHandler(handler IndexerEventHandler)
+}
+
+// IndexerEventHandler is notified of progress while AddIndexers,
+// RemoveIndexer or ReplaceIndexer reindex a populated store. Implementations
+// must not block, since they are invoked while the indexer's write lock is
+// held.
+type IndexerEventHandler interface {
+	// OnIndexProgress is called periodically while reindexing name, with
+	// processed being the number of items reindexed so far out of total.
+	OnIndexProgress(name string, processed, total int)
+	// OnIndexComplete is called once name has finished (re)building, or,
+	// for RemoveIndexer, once name has been removed.
+	OnIndexComplete(name string)
+}
+
+// Operator is a boolean combinator used by IndexQueryTerm when evaluating
+// more than one term in a single query.
+type Operator string
+
+const (
+	// OperatorAnd keeps only the keys present in every term's result set.
+	OperatorAnd Operator = "AND"
+	// OperatorOr keeps the keys present in at least one term's result set.
+	OperatorOr Operator = "OR"
+)
+
+// IndexQueryTerm is a single (indexName, indexedValue) predicate. Negate
+// inverts the term, turning it into a "NOT" within the enclosing Operator:
+// for OperatorAnd it subtracts the term's keys from the running result
+// instead of intersecting with them; for OperatorOr it is not meaningful
+// and is ignored.
+type IndexQueryTerm struct {
+	IndexName    string
+	IndexedValue string
+	Negate       bool
+}
+
+// ByIndexes evaluates several IndexQueryTerms against indexer, combining
+// them with op, and returns the storage keys that satisfy the query. It
+// is the multi-term counterpart to IndexKeys: "pods in namespace=foo AND
+// nodeName=bar" no longer requires calling ByIndex twice and intersecting
+// full object lists in caller code.
+//
+// Only the per-term key sets (sets.String) are ever materialized; objects
+// are never loaded. Terms are evaluated smallest-result-first so that an
+// OperatorAnd query can short-circuit as soon as the running intersection
+// is empty.
+// 依次对每个(indexName, indexedValue)调用IndexKeys得到key集合，
+// 按结果集从小到大排序后再做交集/并集，交集过程中一旦为空立即返回，
+// 避免像调用方手动两次ByIndex那样先取出完整对象列表再做交集
+func ByIndexes(indexer Indexer, op Operator, terms ...IndexQueryTerm) ([]string, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("at least one IndexQueryTerm is required")
+	}
+
+	termKeys := make([]sets.String, len(terms))
+	for i, term := range terms {
+		keys, err := indexer.IndexKeys(term.IndexName, term.IndexedValue)
+		if err != nil {
+			return nil, err
+		}
+		termKeys[i] = sets.NewString(keys...)
+	}
+
+	switch op {
+	case OperatorOr:
+		result := sets.NewString()
+		for _, keys := range termKeys {
+			result = result.Union(keys)
+		}
+		return result.List(), nil
+	case OperatorAnd:
+		// The seed for the intersection must come from a non-negated term:
+		// a negated term alone says nothing about which keys to start from,
+		// only which keys to exclude. Among the non-negated terms, start
+		// from the smallest so an empty intersection short-circuits as
+		// early as possible; if every term is negated, there is no
+		// non-negated set to start from, so seed with the universe of all
+		// storage keys instead (mirroring how ByLabelSelector/ByFieldSelector
+		// handle a bare DoesNotExist/NotEquals-only query).
+		var positive, negative []int
+		for i, term := range terms {
+			if term.Negate {
+				negative = append(negative, i)
+			} else {
+				positive = append(positive, i)
+			}
+		}
+		sort.Slice(positive, func(i, j int) bool {
+			return termKeys[positive[i]].Len() < termKeys[positive[j]].Len()
+		})
+		sort.Slice(negative, func(i, j int) bool {
+			return termKeys[negative[i]].Len() < termKeys[negative[j]].Len()
+		})
+
+		var result sets.String
+		if len(positive) > 0 {
+			result = termKeys[positive[0]]
+			positive = positive[1:]
+		} else {
+			result = sets.NewString(indexer.ListKeys()...)
+		}
+		for _, idx := range positive {
+			result = result.Intersection(termKeys[idx])
+			if result.Len() == 0 {
+				return result.List(), nil
+			}
+		}
+		for _, idx := range negative {
+			result = result.Difference(termKeys[idx])
+			if result.Len() == 0 {
+				break
+			}
+		}
+		return result.List(), nil
+	default:
+		return nil, fmt.Errorf("unknown Operator %q", op)
+	}
+}
+
+// CompositeIndexFunc builds an IndexFunc that indexes an object by the
+// concatenation of several fields, e.g. combining a NamespaceIndex-style
+// field with a nodeName field to answer "namespace=foo AND nodeName=bar"
+// with a single O(1) lookup instead of an intersection of two indices.
+// Each field's IndexFunc must return exactly one value per object; the
+// resulting composite index values are joined with "|", e.g.
+// "kube-system|node-1".
+func CompositeIndexFunc(fields ...IndexFunc) IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		parts := make([]string, len(fields))
+		for i, field := range fields {
+			values, err := field(obj)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) != 1 {
+				return nil, fmt.Errorf("composite index fields must produce exactly one value, field %d produced %d", i, len(values))
+			}
+			parts[i] = values[0]
+		}
+		return []string{strings.Join(parts, "|")}, nil
+	}
+}
+
+// AddCompositeIndexer registers a composite multi-field indexer under name,
+// keeping it in sync with the store via the normal AddIndexers path so that
+// Add/Update/Delete continue to maintain it automatically.
+func AddCompositeIndexer(indexer Indexer, name string, fields ...IndexFunc) error {
+	return indexer.AddIndexers(Indexers{name: CompositeIndexFunc(fields...)})
 }
 
 // IndexFunc knows how to compute the set of indexed values for an object.
@@ -0,0 +1,184 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testObject is a minimal metav1.Object usable with meta.Accessor, shared
+// by the tests in this package.
+type testObject struct {
+	metav1.ObjectMeta
+}
+
+func newTestObject(namespace, name string, labels map[string]string) *testObject {
+	return &testObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+}
+
+func testObjectKeyFunc(obj interface{}) (string, error) {
+	o, ok := obj.(*testObject)
+	if !ok {
+		return "", fmt.Errorf("unexpected object type %T", obj)
+	}
+	return o.Namespace + "/" + o.Name, nil
+}
+
+// testNodeNameIndexFunc indexes by a "nodeName" label, to exercise
+// CompositeIndexFunc alongside MetaNamespaceIndexFunc.
+func testNodeNameIndexFunc(obj interface{}) ([]string, error) {
+	o, ok := obj.(*testObject)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	return []string{o.Labels["nodeName"]}, nil
+}
+
+func newTestIndexer(t *testing.T, indexers Indexers) Indexer {
+	t.Helper()
+	return NewShardedIndexer(testObjectKeyFunc, indexers, 4)
+}
+
+func TestByIndexesAnd(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{NamespaceIndex: MetaNamespaceIndexFunc, LabelSelectorIndex: MetaLabelIndexFunc})
+	for _, obj := range []*testObject{
+		newTestObject("foo", "pod-1", map[string]string{"canary": "true"}),
+		newTestObject("foo", "pod-2", nil),
+		newTestObject("bar", "pod-3", map[string]string{"canary": "true"}),
+	} {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	keys, err := ByIndexes(indexer, OperatorAnd,
+		IndexQueryTerm{IndexName: NamespaceIndex, IndexedValue: "foo"},
+		IndexQueryTerm{IndexName: LabelSelectorIndex, IndexedValue: "canary="},
+	)
+	if err != nil {
+		t.Fatalf("ByIndexes: %v", err)
+	}
+	assertKeys(t, keys, "foo/pod-1")
+}
+
+func TestByIndexesAndNegateDoesNotDependOnSetSize(t *testing.T) {
+	// Regression test: the smallest-set-first heuristic must never pick a
+	// negated term as the seed, or the query's success would depend on
+	// which term happens to have fewer matches.
+	indexer := newTestIndexer(t, Indexers{NamespaceIndex: MetaNamespaceIndexFunc, LabelSelectorIndex: MetaLabelIndexFunc})
+	// Many objects in namespace "foo", only one carries the canary label --
+	// the negated term has by far the smaller result set.
+	if err := indexer.Add(newTestObject("foo", "canary-pod", map[string]string{"canary": "true"})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := indexer.Add(newTestObject("foo", fmt.Sprintf("pod-%d", i), nil)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	keys, err := ByIndexes(indexer, OperatorAnd,
+		IndexQueryTerm{IndexName: NamespaceIndex, IndexedValue: "foo"},
+		IndexQueryTerm{IndexName: LabelSelectorIndex, IndexedValue: "canary=", Negate: true},
+	)
+	if err != nil {
+		t.Fatalf("ByIndexes: %v", err)
+	}
+	assertKeys(t, keys, "foo/pod-0", "foo/pod-1", "foo/pod-2", "foo/pod-3", "foo/pod-4")
+}
+
+func TestByIndexesAndAllTermsNegated(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{LabelSelectorIndex: MetaLabelIndexFunc})
+	if err := indexer.Add(newTestObject("foo", "pod-1", map[string]string{"tier": "frontend"})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := indexer.Add(newTestObject("foo", "pod-2", map[string]string{"tier": "backend"})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	keys, err := ByIndexes(indexer, OperatorAnd,
+		IndexQueryTerm{IndexName: LabelSelectorIndex, IndexedValue: "tier=frontend", Negate: true},
+	)
+	if err != nil {
+		t.Fatalf("ByIndexes: %v", err)
+	}
+	assertKeys(t, keys, "foo/pod-2")
+}
+
+func TestByIndexesOr(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{NamespaceIndex: MetaNamespaceIndexFunc})
+	for _, ns := range []string{"foo", "bar", "baz"} {
+		if err := indexer.Add(newTestObject(ns, "pod", nil)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	keys, err := ByIndexes(indexer, OperatorOr,
+		IndexQueryTerm{IndexName: NamespaceIndex, IndexedValue: "foo"},
+		IndexQueryTerm{IndexName: NamespaceIndex, IndexedValue: "bar"},
+	)
+	if err != nil {
+		t.Fatalf("ByIndexes: %v", err)
+	}
+	assertKeys(t, keys, "bar/pod", "foo/pod")
+}
+
+func TestCompositeIndexFuncAndAddCompositeIndexer(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{})
+	if err := AddCompositeIndexer(indexer, "namespaceNode", MetaNamespaceIndexFunc, testNodeNameIndexFunc); err != nil {
+		t.Fatalf("AddCompositeIndexer: %v", err)
+	}
+
+	if err := indexer.Add(newTestObject("kube-system", "pod-1", map[string]string{"nodeName": "node-1"})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := indexer.Add(newTestObject("kube-system", "pod-2", map[string]string{"nodeName": "node-2"})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	objs, err := indexer.ByIndex("namespaceNode", "kube-system|node-1")
+	if err != nil {
+		t.Fatalf("ByIndex: %v", err)
+	}
+	if len(objs) != 1 || objs[0].(*testObject).Name != "pod-1" {
+		t.Fatalf("expected only pod-1, got %v", objs)
+	}
+}
+
+func assertKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("got keys %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got keys %v, want %v", got, want)
+		}
+	}
+}
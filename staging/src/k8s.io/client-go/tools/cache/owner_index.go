@@ -0,0 +1,96 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// OwnerRefIndex is the lookup name for the index built by
+	// MetaOwnerRefIndexFunc, one entry per metadata.ownerReferences entry.
+	OwnerRefIndex string = "ownerRef"
+
+	// ControllerRefIndex is the lookup name for the index built by
+	// MetaControllerRefIndexFunc, which indexes only the owner reference
+	// with controller: true.
+	ControllerRefIndex string = "controllerRef"
+)
+
+// ownerRefIndexedValue formats an ownerReference the way MetaOwnerRefIndexFunc
+// and MetaControllerRefIndexFunc both do, so GetControllees can build the
+// same key to look an owner up by.
+func ownerRefIndexedValue(apiVersion, kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", apiVersion, kind, namespace, name)
+}
+
+// MetaOwnerRefIndexFunc is a default index function that indexes based on
+// an object's owner references, emitting one indexed value per entry in
+// metadata.ownerReferences formatted as "<apiVersion>/<kind>/<namespace>/<name>".
+// Objects are cluster-scoped from the owner's perspective only in that the
+// owner is assumed to live in the same namespace as the owned object, per
+// the OwnerReference contract.
+func MetaOwnerRefIndexFunc(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("object has no meta: %v", err)
+	}
+	refs := m.GetOwnerReferences()
+	values := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		values = append(values, ownerRefIndexedValue(ref.APIVersion, ref.Kind, m.GetNamespace(), ref.Name))
+	}
+	return values, nil
+}
+
+// MetaControllerRefIndexFunc is a default index function like
+// MetaOwnerRefIndexFunc, but it only emits the owner reference with
+// controller: true, if any. This mirrors metav1.GetControllerOf, which
+// every built-in controller (ReplicaSet, Deployment, Job, ...) already uses
+// to find its single managing controller.
+func MetaControllerRefIndexFunc(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("object has no meta: %v", err)
+	}
+	controllerRef := metav1.GetControllerOfNoCopy(m)
+	if controllerRef == nil {
+		return []string{}, nil
+	}
+	return []string{ownerRefIndexedValue(controllerRef.APIVersion, controllerRef.Kind, m.GetNamespace(), controllerRef.Name)}, nil
+}
+
+// GetControllees returns the objects in indexer whose controller owner
+// reference points at owner, resolved in O(1) through ControllerRefIndex
+// instead of listing owner's namespace and filtering by hand, as every
+// built-in controller (ReplicaSet -> Pods, Deployment -> ReplicaSets,
+// Job -> Pods) does today.
+//
+// apiVersion and kind must be supplied explicitly rather than read off
+// owner's own TypeMeta: typed objects returned by informers/listers
+// virtually always have an empty TypeMeta (APIVersion/Kind are blank
+// unless something goes out of its way to set them), while the
+// OwnerReference entries MetaControllerRefIndexFunc indexes come from the
+// apiserver, which does populate them. Callers already know owner's
+// GroupVersionKind statically -- e.g. appsv1.SchemeGroupVersion.WithKind("ReplicaSet")
+// -- since that's how they registered the informer that produced owner.
+func GetControllees(indexer Indexer, apiVersion, kind string, owner metav1.Object) ([]interface{}, error) {
+	return indexer.ByIndex(ControllerRefIndex, ownerRefIndexedValue(apiVersion, kind, owner.GetNamespace(), owner.GetName()))
+}
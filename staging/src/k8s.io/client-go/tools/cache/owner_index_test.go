@@ -0,0 +1,68 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestGetControllees(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{ControllerRefIndex: MetaControllerRefIndexFunc})
+
+	owner := newTestObject("foo", "rs-1", nil)
+
+	pod1 := newTestObject("foo", "pod-1", nil)
+	pod1.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs-1", Controller: boolPtr(true)},
+	}
+	pod2 := newTestObject("foo", "pod-2", nil)
+	pod2.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs-1", Controller: boolPtr(false)},
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs-2", Controller: boolPtr(true)},
+	}
+	for _, obj := range []*testObject{pod1, pod2} {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	controllees, err := GetControllees(indexer, "apps/v1", "ReplicaSet", owner)
+	if err != nil {
+		t.Fatalf("GetControllees: %v", err)
+	}
+	if len(controllees) != 1 || controllees[0].(*testObject).Name != "pod-1" {
+		t.Fatalf("expected only pod-1 to be controlled by rs-1, got %v", controllees)
+	}
+}
+
+func TestMetaOwnerRefIndexFuncIndexesEveryReference(t *testing.T) {
+	pod := newTestObject("foo", "pod-1", nil)
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs-1", Controller: boolPtr(true)},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "cm-1"},
+	}
+
+	values, err := MetaOwnerRefIndexFunc(pod)
+	if err != nil {
+		t.Fatalf("MetaOwnerRefIndexFunc: %v", err)
+	}
+	assertKeys(t, values, "apps/v1/ReplicaSet/foo/rs-1", "v1/ConfigMap/foo/cm-1")
+}
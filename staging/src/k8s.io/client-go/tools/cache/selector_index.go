@@ -0,0 +1,233 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// LabelSelectorIndex is the lookup name for the inverted label index built
+	// by MetaLabelIndexFunc: one entry per "key=value" pair on an object's
+	// labels, plus a "key=" sentinel meaning "has this label at all".
+	LabelSelectorIndex string = "labels"
+
+	// FieldSelectorIndex is the lookup name for the inverted namespace/name
+	// index built by MetaFieldIndexFunc, following the same "key=value"
+	// convention as LabelSelectorIndex but over an object's namespace and
+	// name fields.
+	FieldSelectorIndex string = "fields"
+
+	// hasLabelSentinel is appended after "=" to record "object has this label
+	// key", independent of its value, so callers can query existence with a
+	// set-based Exists() requirement.
+	hasLabelSentinel = ""
+)
+
+// MetaLabelIndexFunc is a default index function that expands an object's
+// labels into one indexed value per "key=value" pair plus a "key=" sentinel
+// recording that the key is present. This turns Indices[LabelSelectorIndex]
+// into an inverted index of label to storage keys, so ByIndex can answer
+// single-label lookups in O(1) and ByLabelSelector (below) can answer full
+// selector queries without a List+filter scan.
+// 将对象的labels展开为"key=value"形式的索引值集合，并为每个key额外生成一个
+// "key="的哨兵值，用来表示"拥有该label"这一事实，从而支持Exists()类的查询
+func MetaLabelIndexFunc(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("object has no meta: %v", err)
+	}
+	set := m.GetLabels()
+	values := make([]string, 0, len(set)*2)
+	for k, v := range set {
+		values = append(values, k+"="+v)
+		values = append(values, k+"="+hasLabelSentinel)
+	}
+	return values, nil
+}
+
+// ByLabelSelector parses selector with k8s.io/apimachinery/pkg/labels,
+// resolves each requirement against indexer's LabelSelectorIndex through
+// IndexKeys, and intersects/subtracts the resulting sets.String key sets to
+// answer the query. This lets controllers do an indexed label-selector
+// lookup against the local store instead of a full List followed by a
+// selector.Matches() filter, which today is the hottest path in most
+// informer event handlers.
+func ByLabelSelector(indexer Indexer, selector labels.Selector) ([]string, error) {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return nil, fmt.Errorf("selector %q is not index-selectable", selector)
+	}
+	if len(requirements) == 0 {
+		// No requirements means "match everything", same as labels.Everything():
+		// every object in the store satisfies a selector with nothing to check.
+		return indexer.ListKeys(), nil
+	}
+
+	var result sets.String
+	for _, req := range requirements {
+		reqKeys, err := indexKeysForRequirement(indexer, req)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = reqKeys
+			continue
+		}
+		result = result.Intersection(reqKeys)
+		if result.Len() == 0 {
+			break
+		}
+	}
+	if result == nil {
+		return []string{}, nil
+	}
+	return result.List(), nil
+}
+
+// indexKeysForRequirement resolves a single label selector requirement to
+// the set of storage keys that satisfy it, using only IndexKeys lookups
+// against LabelSelectorIndex.
+func indexKeysForRequirement(indexer Indexer, req labels.Requirement) (sets.String, error) {
+	key := req.Key()
+
+	switch req.Operator() {
+	case selection.Equals, selection.DoubleEquals, selection.In:
+		return lookupLabelValues(indexer, key, req.Values().List())
+	case selection.NotEquals, selection.NotIn:
+		// As with selector.Matches, objects without key satisfy the requirement.
+		excluded, err := lookupLabelValues(indexer, key, req.Values().List())
+		if err != nil {
+			return nil, err
+		}
+		return sets.NewString(indexer.ListKeys()...).Difference(excluded), nil
+	case selection.Exists:
+		return lookupLabelExists(indexer, key)
+	case selection.DoesNotExist:
+		hasKey, err := lookupLabelExists(indexer, key)
+		if err != nil {
+			return nil, err
+		}
+		return sets.NewString(indexer.ListKeys()...).Difference(hasKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported selector operator %q for indexed lookup", req.Operator())
+	}
+}
+
+// lookupLabelValues unions the storage keys indexed under key=value for
+// every candidate value.
+func lookupLabelValues(indexer Indexer, key string, values []string) (sets.String, error) {
+	result := sets.NewString()
+	for _, v := range values {
+		keys, err := indexer.IndexKeys(LabelSelectorIndex, key+"="+v)
+		if err != nil {
+			return nil, err
+		}
+		result.Insert(keys...)
+	}
+	return result, nil
+}
+
+// lookupLabelExists returns the storage keys of objects that carry key at
+// all, via the "key=" sentinel written by MetaLabelIndexFunc.
+func lookupLabelExists(indexer Indexer, key string) (sets.String, error) {
+	keys, err := indexer.IndexKeys(LabelSelectorIndex, key+"="+hasLabelSentinel)
+	if err != nil {
+		return nil, err
+	}
+	return sets.NewString(keys...), nil
+}
+
+// MetaFieldIndexFunc is a default index function that indexes an object's
+// namespace and name fields as "namespace=<ns>" and "name=<name>" indexed
+// values, mirroring MetaLabelIndexFunc but for the small, fixed set of
+// fields that field selectors typically match on.
+func MetaFieldIndexFunc(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("object has no meta: %v", err)
+	}
+	return []string{
+		"namespace=" + m.GetNamespace(),
+		"name=" + m.GetName(),
+	}, nil
+}
+
+// ByFieldSelector parses selector's equality requirements and resolves them
+// against indexer's FieldSelectorIndex, the same way ByLabelSelector does
+// for labels. Field selectors only support equality and inequality, so
+// Exists/In/NotIn requirements are rejected.
+func ByFieldSelector(indexer Indexer, selector fields.Selector) ([]string, error) {
+	requirements := selector.Requirements()
+	if len(requirements) == 0 {
+		// No requirements means "match everything", same as fields.Everything().
+		return indexer.ListKeys(), nil
+	}
+
+	var result sets.String
+	for _, req := range requirements {
+		var reqKeys sets.String
+		var err error
+		switch req.Operator {
+		case selection.Equals, selection.DoubleEquals:
+			reqKeys, err = lookupFieldValues(indexer, req.Field, []string{req.Value})
+		case selection.NotEquals:
+			var excluded sets.String
+			excluded, err = lookupFieldValues(indexer, req.Field, []string{req.Value})
+			if err == nil {
+				reqKeys = sets.NewString(indexer.ListKeys()...).Difference(excluded)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported field selector operator %q for indexed lookup", req.Operator)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = reqKeys
+			continue
+		}
+		result = result.Intersection(reqKeys)
+		if result.Len() == 0 {
+			break
+		}
+	}
+	if result == nil {
+		return []string{}, nil
+	}
+	return result.List(), nil
+}
+
+// lookupFieldValues unions the storage keys indexed under field=value for
+// every candidate value.
+func lookupFieldValues(indexer Indexer, field string, values []string) (sets.String, error) {
+	result := sets.NewString()
+	for _, v := range values {
+		keys, err := indexer.IndexKeys(FieldSelectorIndex, field+"="+v)
+		if err != nil {
+			return nil, err
+		}
+		result.Insert(keys...)
+	}
+	return result, nil
+}
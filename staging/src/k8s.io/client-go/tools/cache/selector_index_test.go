@@ -0,0 +1,125 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newLabelIndexer(t *testing.T) Indexer {
+	t.Helper()
+	indexer := newTestIndexer(t, Indexers{LabelSelectorIndex: MetaLabelIndexFunc})
+	for _, obj := range []*testObject{
+		newTestObject("foo", "nginx-1", map[string]string{"app": "nginx", "tier": "frontend"}),
+		newTestObject("foo", "nginx-2", map[string]string{"app": "nginx", "tier": "backend"}),
+		newTestObject("foo", "redis-1", map[string]string{"app": "redis"}),
+	} {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return indexer
+}
+
+func TestByLabelSelectorEquals(t *testing.T) {
+	indexer := newLabelIndexer(t)
+	selector, err := labels.Parse("app=nginx,tier!=frontend")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	keys, err := ByLabelSelector(indexer, selector)
+	if err != nil {
+		t.Fatalf("ByLabelSelector: %v", err)
+	}
+	assertKeys(t, keys, "foo/nginx-2")
+}
+
+func TestByLabelSelectorExists(t *testing.T) {
+	indexer := newLabelIndexer(t)
+	selector, err := labels.Parse("tier")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	keys, err := ByLabelSelector(indexer, selector)
+	if err != nil {
+		t.Fatalf("ByLabelSelector: %v", err)
+	}
+	assertKeys(t, keys, "foo/nginx-1", "foo/nginx-2")
+}
+
+func TestByLabelSelectorDoesNotExist(t *testing.T) {
+	indexer := newLabelIndexer(t)
+	selector, err := labels.Parse("!tier")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	keys, err := ByLabelSelector(indexer, selector)
+	if err != nil {
+		t.Fatalf("ByLabelSelector: %v", err)
+	}
+	assertKeys(t, keys, "foo/redis-1")
+}
+
+func TestByLabelSelectorEverythingMatchesAll(t *testing.T) {
+	indexer := newLabelIndexer(t)
+	keys, err := ByLabelSelector(indexer, labels.Everything())
+	if err != nil {
+		t.Fatalf("ByLabelSelector: %v", err)
+	}
+	assertKeys(t, keys, "foo/nginx-1", "foo/nginx-2", "foo/redis-1")
+}
+
+func TestByFieldSelector(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{FieldSelectorIndex: MetaFieldIndexFunc})
+	for _, obj := range []*testObject{
+		newTestObject("foo", "nginx-1", nil),
+		newTestObject("bar", "nginx-1", nil),
+		newTestObject("foo", "redis-1", nil),
+	} {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	selector := fields.SelectorFromSet(fields.Set{"namespace": "foo", "name": "nginx-1"})
+	keys, err := ByFieldSelector(indexer, selector)
+	if err != nil {
+		t.Fatalf("ByFieldSelector: %v", err)
+	}
+	assertKeys(t, keys, "foo/nginx-1")
+}
+
+func TestByFieldSelectorEverythingMatchesAll(t *testing.T) {
+	indexer := newTestIndexer(t, Indexers{FieldSelectorIndex: MetaFieldIndexFunc})
+	for _, obj := range []*testObject{
+		newTestObject("foo", "nginx-1", nil),
+		newTestObject("bar", "nginx-1", nil),
+	} {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	keys, err := ByFieldSelector(indexer, fields.Everything())
+	if err != nil {
+		t.Fatalf("ByFieldSelector: %v", err)
+	}
+	assertKeys(t, keys, "bar/nginx-1", "foo/nginx-1")
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import "fmt"
+
+// shardedIndexer is an Indexer backed by a shardedStorageBackend instead of
+// the single-lock threadSafeMap. It only ever talks to its backend through
+// the StorageBackend/IndexBackend interfaces, so the sharding strategy
+// (or any future backend) can change without touching this type.
+type shardedIndexer struct {
+	keyFunc KeyFunc
+	backend StorageBackend
+	index   IndexBackend
+}
+
+// NewShardedIndexer builds an Indexer that partitions its keys across
+// shards independent threadSafeMap-style shards, selected by
+// fnv32(key) % shards. Unlike the default Indexer, Add/Update/Delete calls
+// for keys that land in different shards do not contend on the same
+// RWMutex, which matters once a cache holds on the order of 100k+ objects
+// (e.g. pods in a large cluster) and informers are processing events
+// concurrently with readers.
+func NewShardedIndexer(keyFunc KeyFunc, indexers Indexers, shards int) Indexer {
+	backend := NewShardedStorageBackend(indexers, shards)
+	return &shardedIndexer{
+		keyFunc: keyFunc,
+		backend: backend,
+		index:   backend.IndexBackend(),
+	}
+}
+
+func (s *shardedIndexer) Add(obj interface{}) error {
+	key, err := s.keyFunc(obj)
+	if err != nil {
+		return fmt.Errorf("object has no valid key: %v", err)
+	}
+	s.backend.Add(key, obj)
+	return nil
+}
+
+func (s *shardedIndexer) Update(obj interface{}) error {
+	key, err := s.keyFunc(obj)
+	if err != nil {
+		return fmt.Errorf("object has no valid key: %v", err)
+	}
+	s.backend.Update(key, obj)
+	return nil
+}
+
+func (s *shardedIndexer) Delete(obj interface{}) error {
+	key, err := s.keyFunc(obj)
+	if err != nil {
+		return fmt.Errorf("object has no valid key: %v", err)
+	}
+	s.backend.Delete(key)
+	return nil
+}
+
+func (s *shardedIndexer) List() []interface{} {
+	return s.backend.List()
+}
+
+func (s *shardedIndexer) ListKeys() []string {
+	return s.backend.ListKeys()
+}
+
+func (s *shardedIndexer) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	key, err := s.keyFunc(obj)
+	if err != nil {
+		return nil, false, fmt.Errorf("object has no valid key: %v", err)
+	}
+	return s.GetByKey(key)
+}
+
+func (s *shardedIndexer) GetByKey(key string) (item interface{}, exists bool, err error) {
+	item, exists = s.backend.Get(key)
+	return item, exists, nil
+}
+
+func (s *shardedIndexer) Replace(list []interface{}, _ string) error {
+	items := make(map[string]interface{}, len(list))
+	for _, obj := range list {
+		key, err := s.keyFunc(obj)
+		if err != nil {
+			return fmt.Errorf("object has no valid key: %v", err)
+		}
+		items[key] = obj
+	}
+	s.backend.Replace(items)
+	return nil
+}
+
+func (s *shardedIndexer) Resync() error {
+	return nil
+}
+
+func (s *shardedIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	indexFunc, exists := s.index.GetIndexers()[indexName]
+	if !exists {
+		return nil, fmt.Errorf("index with name %s does not exist", indexName)
+	}
+	values, err := indexFunc(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	var result []interface{}
+	for _, value := range values {
+		keys, err := s.index.IndexKeys(indexName, value)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if item, exists := s.backend.Get(key); exists {
+				result = append(result, item)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *shardedIndexer) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	return s.index.IndexKeys(indexName, indexedValue)
+}
+
+func (s *shardedIndexer) ListIndexFuncValues(indexName string) []string {
+	return s.index.ListIndexFuncValues(indexName)
+}
+
+func (s *shardedIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	keys, err := s.index.ByIndex(indexName, indexedValue)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if item, exists := s.backend.Get(key); exists {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func (s *shardedIndexer) GetIndexers() Indexers {
+	return s.index.GetIndexers()
+}
+
+func (s *shardedIndexer) AddIndexers(newIndexers Indexers) error {
+	return s.index.AddIndexers(newIndexers)
+}
+
+func (s *shardedIndexer) RemoveIndexer(name string) error {
+	return s.index.RemoveIndexer(name)
+}
+
+func (s *shardedIndexer) ReplaceIndexer(name string, f IndexFunc) error {
+	return s.index.ReplaceIndexer(name, f)
+}
+
+func (s *shardedIndexer) SetIndexerEventHandler(handler IndexerEventHandler) {
+	s.index.SetIndexerEventHandler(handler)
+}
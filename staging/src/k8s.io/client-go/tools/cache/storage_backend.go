@@ -0,0 +1,499 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// StorageBackend is the persistence layer underneath an Indexer. Splitting
+// it out of threadSafeMap lets an Indexer be built on something other than
+// a single map guarded by a single RWMutex, e.g. the sharded backend below,
+// without changing anything above the Store/Indexer interfaces.
+type StorageBackend interface {
+	Add(key string, obj interface{})
+	Update(key string, obj interface{})
+	Delete(key string)
+	Get(key string) (item interface{}, exists bool)
+	List() []interface{}
+	ListKeys() []string
+	Replace(items map[string]interface{})
+
+	// IndexBackend returns the sub-interface responsible for maintaining
+	// this backend's indices.
+	IndexBackend() IndexBackend
+}
+
+// IndexBackend is the index-maintenance half of a StorageBackend: the set
+// operations needed to keep Indices in sync as items are written, and to
+// answer Index/IndexKeys/ByIndex/ListIndexFuncValues lookups.
+type IndexBackend interface {
+	GetIndexers() Indexers
+	AddIndexers(newIndexers Indexers) error
+	RemoveIndexer(name string) error
+	ReplaceIndexer(name string, f IndexFunc) error
+	SetIndexerEventHandler(handler IndexerEventHandler)
+	IndexKeys(indexName, indexedValue string) ([]string, error)
+	ListIndexFuncValues(indexName string) []string
+	ByIndex(indexName, indexedValue string) ([]string, error)
+}
+
+// fnv32 hashes key with FNV-1a, used to pick a shard for a storage key. It
+// is unexported and deterministic so the same key always maps to the same
+// shard within a process.
+func fnv32(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+// shardedStorageBackend partitions keys across n independent shards, each
+// with its own RWMutex, items map and Indices, so Add/Update/Delete on keys
+// that hash to different shards never contend. This targets the
+// threadSafeMap single-RWMutex bottleneck that shows up once a cache holds
+// on the order of 100k+ objects and an informer is processing events
+// concurrently with readers.
+type shardedStorageBackend struct {
+	shards []*mapShard
+
+	// indexersLock guards indexers itself (registering/removing names).
+	// Per-shard Indices data is guarded independently by each mapShard's
+	// own lock; reading an IndexFunc out of indexers to run it against an
+	// object, as updateIndices does, only requires indexersLock.RLock().
+	indexersLock sync.RWMutex
+	indexers     Indexers
+
+	handlerLock sync.RWMutex
+	handler     IndexerEventHandler
+}
+
+// mapShard is one partition of a shardedStorageBackend: a plain map behind
+// its own lock, mirroring what threadSafeMap does for the whole store.
+type mapShard struct {
+	lock    sync.RWMutex
+	items   map[string]interface{}
+	indices Indices
+}
+
+// NewShardedStorageBackend builds a StorageBackend with n independent
+// shards selected by fnv32(key) % n. indexers seeds every shard with the
+// same set of indices so that index lookups can be scattered/gathered
+// across shards transparently.
+func NewShardedStorageBackend(indexers Indexers, n int) StorageBackend {
+	if n <= 0 {
+		n = 1
+	}
+	shards := make([]*mapShard, n)
+	for i := range shards {
+		shards[i] = &mapShard{
+			items:   map[string]interface{}{},
+			indices: Indices{},
+		}
+	}
+	return &shardedStorageBackend{shards: shards, indexers: indexers}
+}
+
+// NewStorageBackend builds the single-lock StorageBackend: one mapShard
+// guarded by one RWMutex; every key hashes to that one shard, so there is
+// no cross-shard fan-out. It is
+// the map-based counterpart to NewShardedStorageBackend, kept as its own
+// constructor (rather than leaving callers to notice that n=1 degenerates
+// to the same thing) so the two backends this package ships -- single-lock
+// and sharded -- are both named and documented as implementations of
+// StorageBackend.
+func NewStorageBackend(indexers Indexers) StorageBackend {
+	return NewShardedStorageBackend(indexers, 1)
+}
+
+func (s *shardedStorageBackend) shardFor(key string) *mapShard {
+	return s.shards[fnv32(key)%uint32(len(s.shards))]
+}
+
+func (s *shardedStorageBackend) Add(key string, obj interface{}) { s.Update(key, obj) }
+
+func (s *shardedStorageBackend) Update(key string, obj interface{}) {
+	shard := s.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	s.indexersLock.RLock()
+	defer s.indexersLock.RUnlock()
+	oldObj := shard.items[key]
+	shard.items[key] = obj
+	shard.updateIndices(oldObj, obj, key, s.indexers)
+}
+
+func (s *shardedStorageBackend) Delete(key string) {
+	shard := s.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	s.indexersLock.RLock()
+	defer s.indexersLock.RUnlock()
+	if oldObj, exists := shard.items[key]; exists {
+		shard.updateIndices(oldObj, nil, key, s.indexers)
+		delete(shard.items, key)
+	}
+}
+
+func (s *shardedStorageBackend) Get(key string) (interface{}, bool) {
+	shard := s.shardFor(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	item, exists := shard.items[key]
+	return item, exists
+}
+
+func (s *shardedStorageBackend) List() []interface{} {
+	result := make([]interface{}, 0)
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		for _, item := range shard.items {
+			result = append(result, item)
+		}
+		shard.lock.RUnlock()
+	}
+	return result
+}
+
+func (s *shardedStorageBackend) ListKeys() []string {
+	result := make([]string, 0)
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		for key := range shard.items {
+			result = append(result, key)
+		}
+		shard.lock.RUnlock()
+	}
+	return result
+}
+
+func (s *shardedStorageBackend) Replace(items map[string]interface{}) {
+	grouped := make([]map[string]interface{}, len(s.shards))
+	for i := range grouped {
+		grouped[i] = map[string]interface{}{}
+	}
+	for key, obj := range items {
+		idx := fnv32(key) % uint32(len(s.shards))
+		grouped[idx][key] = obj
+	}
+	for i, shard := range s.shards {
+		shard.lock.Lock()
+		s.indexersLock.RLock()
+		shard.items = grouped[i]
+		shard.indices = Indices{}
+		for key, obj := range shard.items {
+			shard.updateIndices(nil, obj, key, s.indexers)
+		}
+		s.indexersLock.RUnlock()
+		shard.lock.Unlock()
+	}
+}
+
+func (s *shardedStorageBackend) IndexBackend() IndexBackend { return s }
+
+func (s *shardedStorageBackend) GetIndexers() Indexers {
+	s.indexersLock.RLock()
+	defer s.indexersLock.RUnlock()
+	result := make(Indexers, len(s.indexers))
+	for name, f := range s.indexers {
+		result[name] = f
+	}
+	return result
+}
+
+// lockAllShards locks every shard, in slice order, and returns a function
+// that unlocks them all. Callers only hold every lock at once for the brief
+// final commit step of a reindex (commitIndices plus the RemoveIndexer/
+// AddIndexers/ReplaceIndexer bookkeeping around it) -- never for the
+// expensive walk-every-item work in computeIndices, which takes at most one
+// shard's lock at a time instead. The fixed slice order matches the per-key
+// lock ordering Update/Delete/Replace already use, so the two never
+// deadlock against each other.
+func (s *shardedStorageBackend) lockAllShards() func() {
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+	}
+	return func() {
+		for _, shard := range s.shards {
+			shard.lock.Unlock()
+		}
+	}
+}
+
+// AddIndexers registers newIndexers and reindexes the store to populate
+// them. The expensive part -- walking every item through each new
+// IndexFunc -- runs in computeIndices, which takes at most one shard's
+// lock at a time so concurrent Add/Update/Delete/reads against other
+// shards (and even the same shard, between one key and the next) are never
+// blocked for the whole reindex. Only the final commit, which is a cheap
+// map assignment per shard, takes every shard's lock together.
+func (s *shardedStorageBackend) AddIndexers(newIndexers Indexers) error {
+	if err := s.checkNoConflict(newIndexers); err != nil {
+		return err
+	}
+
+	built, err := s.computeIndices(newIndexers, len(s.ListKeys()))
+	if err != nil {
+		return err
+	}
+
+	// Acquire shard locks before indexersLock, same order Update/Delete use,
+	// so a concurrent commit here can never deadlock against them.
+	unlock := s.lockAllShards()
+	defer unlock()
+	s.indexersLock.Lock()
+	defer s.indexersLock.Unlock()
+	if err := s.checkNoConflictLocked(newIndexers); err != nil {
+		return err
+	}
+	s.commitIndices(built)
+	for name, indexFunc := range newIndexers {
+		s.indexers[name] = indexFunc
+	}
+	return nil
+}
+
+func (s *shardedStorageBackend) checkNoConflict(newIndexers Indexers) error {
+	s.indexersLock.RLock()
+	defer s.indexersLock.RUnlock()
+	return s.checkNoConflictLocked(newIndexers)
+}
+
+// checkNoConflictLocked is checkNoConflict's body; the caller must already
+// hold indexersLock (for reading or writing).
+func (s *shardedStorageBackend) checkNoConflictLocked(newIndexers Indexers) error {
+	for name := range newIndexers {
+		if _, exists := s.indexers[name]; exists {
+			return fmt.Errorf("indexer conflict: %s", name)
+		}
+	}
+	return nil
+}
+
+func (s *shardedStorageBackend) RemoveIndexer(name string) error {
+	s.indexersLock.RLock()
+	_, exists := s.indexers[name]
+	s.indexersLock.RUnlock()
+	if !exists {
+		return nil
+	}
+
+	// Same shards-then-indexersLock order as AddIndexers/ReplaceIndexer.
+	unlock := s.lockAllShards()
+	defer unlock()
+	s.indexersLock.Lock()
+	defer s.indexersLock.Unlock()
+	if _, exists := s.indexers[name]; !exists {
+		return nil
+	}
+	for _, shard := range s.shards {
+		delete(shard.indices, name)
+	}
+	delete(s.indexers, name)
+	s.notify(func(h IndexerEventHandler) { h.OnIndexComplete(name) })
+	return nil
+}
+
+// ReplaceIndexer swaps the IndexFunc registered under name for f and
+// rebuilds only Indices[name], with the same brief-lock-for-commit-only
+// strategy as AddIndexers.
+func (s *shardedStorageBackend) ReplaceIndexer(name string, f IndexFunc) error {
+	s.indexersLock.RLock()
+	_, exists := s.indexers[name]
+	s.indexersLock.RUnlock()
+	if !exists {
+		return fmt.Errorf("index with name %s does not exist", name)
+	}
+
+	built, err := s.computeIndices(Indexers{name: f}, len(s.ListKeys()))
+	if err != nil {
+		return err
+	}
+
+	// Same shards-then-indexersLock order as AddIndexers/RemoveIndexer.
+	unlock := s.lockAllShards()
+	defer unlock()
+	s.indexersLock.Lock()
+	defer s.indexersLock.Unlock()
+	if _, exists := s.indexers[name]; !exists {
+		return fmt.Errorf("index with name %s does not exist", name)
+	}
+	s.commitIndices(built)
+	s.indexers[name] = f
+	return nil
+}
+
+func (s *shardedStorageBackend) SetIndexerEventHandler(handler IndexerEventHandler) {
+	s.handlerLock.Lock()
+	defer s.handlerLock.Unlock()
+	s.handler = handler
+}
+
+func (s *shardedStorageBackend) notify(fn func(IndexerEventHandler)) {
+	s.handlerLock.RLock()
+	handler := s.handler
+	s.handlerLock.RUnlock()
+	if handler != nil {
+		fn(handler)
+	}
+}
+
+// computeIndices builds Index values for every (name, indexFunc) in
+// newIndexers across every shard's current items, without mutating any
+// shard's Indices and without ever holding more than one shard's lock at a
+// time: each shard's items are snapshotted under a brief RLock, then
+// indexFunc runs against that snapshot with no lock held at all, so
+// concurrent Add/Update/Delete against any shard -- including the one just
+// snapshotted -- are never blocked by a large reindex. A key written
+// concurrent with the snapshot may be reflected in the eventual commit or
+// may not; it will in either case be correctly (re)indexed by the next
+// Add/Update/Delete that touches it, same as any other index.
+//
+// The caller must only pass the result to commitIndices if every indexFunc
+// succeeds for every item -- this keeps a failing indexFunc from leaving
+// some other, successfully-computed indexer's data committed while it
+// itself never gets registered in s.indexers.
+func (s *shardedStorageBackend) computeIndices(newIndexers Indexers, total int) (map[string][]Index, error) {
+	built := make(map[string][]Index, len(newIndexers))
+	for name, indexFunc := range newIndexers {
+		perShard := make([]Index, len(s.shards))
+		processed := 0
+		for i, shard := range s.shards {
+			shard.lock.RLock()
+			items := make(map[string]interface{}, len(shard.items))
+			for key, obj := range shard.items {
+				items[key] = obj
+			}
+			shard.lock.RUnlock()
+
+			index := Index{}
+			for key, obj := range items {
+				values, err := indexFunc(obj)
+				if err != nil {
+					return nil, err
+				}
+				for _, value := range values {
+					set := index[value]
+					if set == nil {
+						set = sets.String{}
+						index[value] = set
+					}
+					set.Insert(key)
+				}
+				processed++
+			}
+			perShard[i] = index
+			s.notify(func(h IndexerEventHandler) { h.OnIndexProgress(name, processed, total) })
+		}
+		built[name] = perShard
+	}
+	return built, nil
+}
+
+// commitIndices assigns the Index values computed by computeIndices into
+// each shard's Indices. The caller must hold every shard's lock, e.g. via
+// lockAllShards.
+func (s *shardedStorageBackend) commitIndices(built map[string][]Index) {
+	for name, perShard := range built {
+		for i, shard := range s.shards {
+			shard.indices[name] = perShard[i]
+		}
+		s.notify(func(h IndexerEventHandler) { h.OnIndexComplete(name) })
+	}
+}
+
+func (s *shardedStorageBackend) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	s.indexersLock.RLock()
+	_, exists := s.indexers[indexName]
+	s.indexersLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("index with name %s does not exist", indexName)
+	}
+	result := sets.NewString()
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		if index, ok := shard.indices[indexName]; ok {
+			result = result.Union(index[indexedValue])
+		}
+		shard.lock.RUnlock()
+	}
+	return result.List(), nil
+}
+
+func (s *shardedStorageBackend) ListIndexFuncValues(indexName string) []string {
+	result := sets.NewString()
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		if index, ok := shard.indices[indexName]; ok {
+			for value := range index {
+				result.Insert(value)
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	return result.List()
+}
+
+func (s *shardedStorageBackend) ByIndex(indexName, indexedValue string) ([]string, error) {
+	return s.IndexKeys(indexName, indexedValue)
+}
+
+// updateIndices keeps shard.indices in sync with a single key's old/new
+// object, mirroring threadSafeMap's updateIndices. Callers must hold
+// shard.lock for writing.
+func (shard *mapShard) updateIndices(oldObj, newObj interface{}, key string, indexers Indexers) {
+	for name, indexFunc := range indexers {
+		index, ok := shard.indices[name]
+		if !ok {
+			index = Index{}
+			shard.indices[name] = index
+		}
+		if oldObj != nil {
+			if oldValues, err := indexFunc(oldObj); err == nil {
+				for _, value := range oldValues {
+					if set, ok := index[value]; ok {
+						set.Delete(key)
+						if set.Len() == 0 {
+							delete(index, value)
+						}
+					}
+				}
+			}
+		}
+		if newObj != nil {
+			if newValues, err := indexFunc(newObj); err == nil {
+				for _, value := range newValues {
+					set, ok := index[value]
+					if !ok {
+						set = sets.String{}
+						index[value] = set
+					}
+					set.Insert(key)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestNewStorageBackendIsSingleLock(t *testing.T) {
+	backend := NewStorageBackend(Indexers{NamespaceIndex: MetaNamespaceIndexFunc})
+	if shards := len(backend.(*shardedStorageBackend).shards); shards != 1 {
+		t.Fatalf("expected a single shard, got %d", shards)
+	}
+	backend.Add("foo/pod-1", newTestObject("foo", "pod-1", nil))
+	backend.Add("foo/pod-2", newTestObject("foo", "pod-2", nil))
+
+	keys, err := backend.IndexBackend().ByIndex(NamespaceIndex, "foo")
+	if err != nil {
+		t.Fatalf("ByIndex: %v", err)
+	}
+	assertKeys(t, keys, "foo/pod-1", "foo/pod-2")
+}
+
+func TestShardedIndexerBasicCRUD(t *testing.T) {
+	indexer := NewShardedIndexer(testObjectKeyFunc, Indexers{NamespaceIndex: MetaNamespaceIndexFunc}, 4)
+
+	obj := newTestObject("foo", "pod-1", nil)
+	if err := indexer.Add(obj); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if got, exists, err := indexer.GetByKey("foo/pod-1"); err != nil || !exists || got.(*testObject).Name != "pod-1" {
+		t.Fatalf("GetByKey: got=%v exists=%v err=%v", got, exists, err)
+	}
+
+	updated := newTestObject("foo", "pod-1", map[string]string{"updated": "true"})
+	if err := indexer.Update(updated); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, _, _ := indexer.GetByKey("foo/pod-1")
+	if got.(*testObject).Labels["updated"] != "true" {
+		t.Fatalf("expected Update to replace the stored object, got %v", got)
+	}
+
+	if err := indexer.Delete(updated); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, exists, _ := indexer.GetByKey("foo/pod-1"); exists {
+		t.Fatalf("expected pod-1 to be gone after Delete")
+	}
+}
+
+func TestShardedIndexerAddIndexersReindexesExistingData(t *testing.T) {
+	indexer := NewShardedIndexer(testObjectKeyFunc, Indexers{}, 4)
+	for i := 0; i < 20; i++ {
+		if err := indexer.Add(newTestObject("foo", fmt.Sprintf("pod-%d", i), nil)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// Per the updated contract, AddIndexers on a populated store must
+	// immediately make the new index queryable -- no "undefined" window.
+	if err := indexer.AddIndexers(Indexers{NamespaceIndex: MetaNamespaceIndexFunc}); err != nil {
+		t.Fatalf("AddIndexers: %v", err)
+	}
+	objs, err := indexer.ByIndex(NamespaceIndex, "foo")
+	if err != nil {
+		t.Fatalf("ByIndex: %v", err)
+	}
+	if len(objs) != 20 {
+		t.Fatalf("expected all 20 pre-existing objects to be indexed, got %d", len(objs))
+	}
+}
+
+func TestShardedIndexerRemoveAndReplaceIndexer(t *testing.T) {
+	indexer := NewShardedIndexer(testObjectKeyFunc, Indexers{NamespaceIndex: MetaNamespaceIndexFunc}, 4)
+	if err := indexer.Add(newTestObject("foo", "pod-1", nil)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if err := indexer.RemoveIndexer(NamespaceIndex); err != nil {
+		t.Fatalf("RemoveIndexer: %v", err)
+	}
+	if _, err := indexer.ByIndex(NamespaceIndex, "foo"); err == nil {
+		t.Fatalf("expected ByIndex to fail after RemoveIndexer")
+	}
+
+	if err := indexer.AddIndexers(Indexers{"upper": func(obj interface{}) ([]string, error) {
+		return []string{obj.(*testObject).Namespace}, nil
+	}}); err != nil {
+		t.Fatalf("AddIndexers: %v", err)
+	}
+	if err := indexer.ReplaceIndexer("upper", func(obj interface{}) ([]string, error) {
+		return []string{"replaced"}, nil
+	}); err != nil {
+		t.Fatalf("ReplaceIndexer: %v", err)
+	}
+	objs, err := indexer.ByIndex("upper", "replaced")
+	if err != nil {
+		t.Fatalf("ByIndex: %v", err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("expected ReplaceIndexer to take effect, got %d objects", len(objs))
+	}
+}
+
+// recordingHandler is an IndexerEventHandler that just remembers which
+// index names it was notified about.
+type recordingHandler struct {
+	completed []string
+}
+
+func (r *recordingHandler) OnIndexProgress(name string, processed, total int) {}
+func (r *recordingHandler) OnIndexComplete(name string)                       { r.completed = append(r.completed, name) }
+
+func TestShardedIndexerNotifiesHandlerOnRemoveIndexer(t *testing.T) {
+	indexer := NewShardedIndexer(testObjectKeyFunc, Indexers{NamespaceIndex: MetaNamespaceIndexFunc}, 4)
+	handler := &recordingHandler{}
+	indexer.SetIndexerEventHandler(handler)
+
+	if err := indexer.RemoveIndexer(NamespaceIndex); err != nil {
+		t.Fatalf("RemoveIndexer: %v", err)
+	}
+	assertKeys(t, handler.completed, NamespaceIndex)
+}
+
+// TestShardedIndexerConcurrentWritesAndReindex exercises the lock-ordering
+// fix from the chunk0-5 follow-up: concurrent Update against a
+// populated store must never deadlock or race with a concurrent
+// AddIndexers/ReplaceIndexer. Run with -race to catch data races on
+// s.indexers and shard.indices.
+func TestShardedIndexerConcurrentWritesAndReindex(t *testing.T) {
+	indexer := NewShardedIndexer(testObjectKeyFunc, Indexers{NamespaceIndex: MetaNamespaceIndexFunc}, 8)
+	for i := 0; i < 200; i++ {
+		if err := indexer.Add(newTestObject("foo", fmt.Sprintf("pod-%d", i), nil)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		i := 0
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("pod-%d", i%200)
+			if err := indexer.Update(newTestObject("foo", key, map[string]string{"n": fmt.Sprint(i)})); err != nil {
+				t.Errorf("Update: %v", err)
+				return
+			}
+			i++
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("byN%d", i)
+		if err := indexer.AddIndexers(Indexers{name: func(obj interface{}) ([]string, error) {
+			return []string{obj.(*testObject).Labels["n"]}, nil
+		}}); err != nil {
+			t.Fatalf("AddIndexers: %v", err)
+		}
+		if err := indexer.ReplaceIndexer(name, func(obj interface{}) ([]string, error) {
+			return []string{obj.(*testObject).Namespace}, nil
+		}); err != nil {
+			t.Fatalf("ReplaceIndexer: %v", err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}